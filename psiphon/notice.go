@@ -0,0 +1,350 @@
+/*
+ * Copyright (c) 2015, Psiphon Inc.
+ * All rights reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package psiphon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// NoticeObject is the JSON structure used for notice input and output. The
+// data payload field is left as JSON so it may be interpreted differently
+// by different consumers.
+type NoticeObject struct {
+	NoticeType string          `json:"noticeType"`
+	Data       json.RawMessage `json:"data"`
+	Timestamp  string          `json:"timestamp"`
+	Severity   string          `json:"severity"`
+
+	// rawLine is the original, undecoded notice line, when one is
+	// available. It's unexported so it's never marshaled back out as
+	// JSON; it exists only so NoticeConsoleRewriterFormatJSON can pass
+	// through the original line verbatim, rather than re-encoding just
+	// the four fields above and losing any other fields a producer set.
+	rawLine []byte
+}
+
+// NoticeSeverity is the relative importance of a notice, used to filter
+// and, on a TTY, color console output.
+type NoticeSeverity int
+
+const (
+	NoticeSeverityDebug NoticeSeverity = iota
+	NoticeSeverityInfo
+	NoticeSeverityWarn
+	NoticeSeverityError
+)
+
+// String returns the lower-case name of the severity, which is also the
+// value expected in the NoticeObject "severity" field.
+func (severity NoticeSeverity) String() string {
+	switch severity {
+	case NoticeSeverityDebug:
+		return "debug"
+	case NoticeSeverityWarn:
+		return "warn"
+	case NoticeSeverityError:
+		return "error"
+	default:
+		return "info"
+	}
+}
+
+// parseNoticeSeverity maps a notice's "severity" field to a NoticeSeverity.
+// Notices with an empty or unrecognized severity are treated as info, since
+// most existing notice types predate the severity field.
+func parseNoticeSeverity(value string) NoticeSeverity {
+	switch value {
+	case "debug":
+		return NoticeSeverityDebug
+	case "warn":
+		return NoticeSeverityWarn
+	case "error":
+		return NoticeSeverityError
+	default:
+		return NoticeSeverityInfo
+	}
+}
+
+// NoticeConsoleRewriterFormat selects the output format used by a
+// NoticeConsoleRewriter.
+type NoticeConsoleRewriterFormat int
+
+const (
+	// NoticeConsoleRewriterFormatHuman rewrites each notice as a single
+	// space-delimited, human-readable line. This is the default format.
+	NoticeConsoleRewriterFormatHuman NoticeConsoleRewriterFormat = iota
+
+	// NoticeConsoleRewriterFormatJSON passes through the original
+	// JSON-format notice line unmodified. For notices synthesized
+	// internally, such as SinkDroppedNotices, where no original line
+	// exists, it falls back to re-encoding the known NoticeObject fields.
+	NoticeConsoleRewriterFormatJSON
+
+	// NoticeConsoleRewriterFormatLogfmt rewrites each notice as a
+	// logfmt-style "key=value" line, suitable for journald and other
+	// logfmt consumers.
+	NoticeConsoleRewriterFormatLogfmt
+)
+
+// ansi color codes used when writing to a TTY.
+const (
+	ansiColorReset  = "\033[0m"
+	ansiColorGray   = "\033[90m"
+	ansiColorCyan   = "\033[36m"
+	ansiColorYellow = "\033[1;33m"
+	ansiColorRed    = "\033[1;31m"
+)
+
+func ansiColorForSeverity(severity NoticeSeverity) string {
+	switch severity {
+	case NoticeSeverityDebug:
+		return ansiColorGray
+	case NoticeSeverityWarn:
+		return ansiColorYellow
+	case NoticeSeverityError:
+		return ansiColorRed
+	default:
+		return ansiColorCyan
+	}
+}
+
+// isTerminal returns true when writer is connected to a character device,
+// such as a TTY. Writers that aren't *os.File -- for example, an
+// in-memory buffer or a network connection -- are never considered
+// terminals.
+func isTerminal(writer io.Writer) bool {
+	file, ok := writer.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return (info.Mode() & os.ModeCharDevice) != 0
+}
+
+// NoticeConsoleRewriterOption is a configuration option for
+// NewNoticeConsoleRewriter.
+type NoticeConsoleRewriterOption func(*ConsoleSink)
+
+// NoticeConsoleRewriterAllowedTypes restricts output to only the given
+// NoticeType values. When not specified, all notice types not excluded by
+// NoticeConsoleRewriterDeniedTypes are output.
+func NoticeConsoleRewriterAllowedTypes(noticeTypes ...string) NoticeConsoleRewriterOption {
+	return func(sink *ConsoleSink) {
+		sink.allowedTypes = make(map[string]bool)
+		for _, noticeType := range noticeTypes {
+			sink.allowedTypes[noticeType] = true
+		}
+	}
+}
+
+// NoticeConsoleRewriterDeniedTypes excludes the given NoticeType values
+// from output. This is useful for dropping high-volume notice types, such
+// as bytes-transferred counters, without patching core code.
+func NoticeConsoleRewriterDeniedTypes(noticeTypes ...string) NoticeConsoleRewriterOption {
+	return func(sink *ConsoleSink) {
+		sink.deniedTypes = make(map[string]bool)
+		for _, noticeType := range noticeTypes {
+			sink.deniedTypes[noticeType] = true
+		}
+	}
+}
+
+// NoticeConsoleRewriterFormatOption selects the output format. The default
+// format is NoticeConsoleRewriterFormatHuman.
+func NoticeConsoleRewriterFormatOption(format NoticeConsoleRewriterFormat) NoticeConsoleRewriterOption {
+	return func(sink *ConsoleSink) {
+		sink.format = format
+	}
+}
+
+// NoticeConsoleRewriterColor overrides the auto-detected TTY coloring
+// behavior. Pass true to force color output, or false to disable it.
+func NoticeConsoleRewriterColor(enabled bool) NoticeConsoleRewriterOption {
+	return func(sink *ConsoleSink) {
+		sink.color = enabled
+		sink.colorIsSet = true
+	}
+}
+
+// NoticeConsoleRewriter consumes JSON-format notice input and parses each
+// notice and rewrites in a more human-readable format more suitable for
+// console output. The data payload field is left as JSON.
+//
+// NoticeConsoleRewriter is a thin adapter that splits its input into
+// notice lines and hands each decoded notice to an underlying ConsoleSink,
+// which performs the actual filtering, coloring, and formatting.
+type NoticeConsoleRewriter struct {
+	mutex  sync.Mutex
+	buffer []byte
+	sink   *ConsoleSink
+}
+
+// NewNoticeConsoleRewriter initializes a new NoticeConsoleRewriter. Notices
+// below minLevel are dropped. With no options, the rewriter reproduces the
+// original behavior: every notice is printed to writer as a
+// "timestamp noticeType data" line. When writer is a terminal, that line
+// is wrapped in ANSI color codes unless NoticeConsoleRewriterColor(false)
+// is passed.
+//
+// No notice producer currently populates NoticeObject.Severity, so every
+// existing notice parses as NoticeSeverityInfo (see parseNoticeSeverity).
+// Passing a minLevel above NoticeSeverityInfo will drop all of them,
+// including errors. Until producers are updated to tag their own
+// severity, callers should pass NoticeSeverityDebug to preserve existing
+// output.
+func NewNoticeConsoleRewriter(
+	writer io.Writer,
+	minLevel NoticeSeverity,
+	opts ...NoticeConsoleRewriterOption) *NoticeConsoleRewriter {
+
+	return &NoticeConsoleRewriter{
+		sink: NewConsoleSink(writer, minLevel, opts...),
+	}
+}
+
+// Write implements io.Writer.
+func (rewriter *NoticeConsoleRewriter) Write(p []byte) (n int, err error) {
+	rewriter.mutex.Lock()
+	defer rewriter.mutex.Unlock()
+
+	rewriter.buffer = append(rewriter.buffer, p...)
+
+	for {
+		index := bytes.IndexByte(rewriter.buffer, '\n')
+		if index == -1 {
+			break
+		}
+		line := rewriter.buffer[:index]
+		rewriter.buffer = rewriter.buffer[index+1:]
+
+		var noticeObject NoticeObject
+		_ = json.Unmarshal(line, &noticeObject)
+
+		// line aliases rewriter.buffer's underlying array, which is
+		// about to be overwritten by future appends, so it must be
+		// copied before being retained past this iteration.
+		noticeObject.rawLine = append([]byte(nil), line...)
+
+		rewriter.sink.HandleNotice(&noticeObject)
+	}
+
+	return len(p), nil
+}
+
+// ConsoleSink formats decoded notices for console output: filtering by
+// minimum severity and notice type, colorizing by severity when writing to
+// a TTY, and rendering in the configured NoticeConsoleRewriterFormat.
+type ConsoleSink struct {
+	writer       io.Writer
+	minLevel     NoticeSeverity
+	allowedTypes map[string]bool
+	deniedTypes  map[string]bool
+	format       NoticeConsoleRewriterFormat
+	color        bool
+	colorIsSet   bool
+}
+
+// NewConsoleSink initializes a new ConsoleSink.
+func NewConsoleSink(
+	writer io.Writer,
+	minLevel NoticeSeverity,
+	opts ...NoticeConsoleRewriterOption) *ConsoleSink {
+
+	sink := &ConsoleSink{
+		writer:   writer,
+		minLevel: minLevel,
+	}
+	for _, opt := range opts {
+		opt(sink)
+	}
+	if !sink.colorIsSet {
+		sink.color = isTerminal(writer)
+	}
+	return sink
+}
+
+// HandleNotice implements NoticeSink.
+func (sink *ConsoleSink) HandleNotice(notice *NoticeObject) {
+
+	if parseNoticeSeverity(notice.Severity) < sink.minLevel {
+		return
+	}
+	if sink.allowedTypes != nil && !sink.allowedTypes[notice.NoticeType] {
+		return
+	}
+	if sink.deniedTypes != nil && sink.deniedTypes[notice.NoticeType] {
+		return
+	}
+
+	switch sink.format {
+
+	case NoticeConsoleRewriterFormatJSON:
+		// Pass through the original line verbatim when it's available,
+		// rather than re-encoding just the fields NoticeObject knows
+		// about: re-encoding would drop any other fields a producer
+		// set, and add a spurious empty "severity" field to notices
+		// that never had one.
+		encodedNotice := notice.rawLine
+		if encodedNotice == nil {
+			var err error
+			encodedNotice, err = json.Marshal(notice)
+			if err != nil {
+				return
+			}
+		}
+		fmt.Fprintf(sink.writer, "%s\n", encodedNotice)
+
+	case NoticeConsoleRewriterFormatLogfmt:
+		fmt.Fprintf(sink.writer,
+			"timestamp=%q severity=%q noticeType=%q data=%q\n",
+			notice.Timestamp, notice.Severity, notice.NoticeType, string(notice.Data))
+
+	default:
+		if sink.color {
+			colorCode := ansiColorForSeverity(parseNoticeSeverity(notice.Severity))
+			fmt.Fprintf(sink.writer,
+				"%s%s %s %s%s\n",
+				colorCode,
+				notice.Timestamp,
+				notice.NoticeType,
+				string(notice.Data),
+				ansiColorReset)
+		} else {
+			fmt.Fprintf(sink.writer,
+				"%s %s %s\n",
+				notice.Timestamp,
+				notice.NoticeType,
+				string(notice.Data))
+		}
+	}
+}
+
+// Close implements NoticeSink.
+func (sink *ConsoleSink) Close() error {
+	return nil
+}
@@ -0,0 +1,214 @@
+/*
+ * Copyright (c) 2015, Psiphon Inc.
+ * All rights reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package psiphon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// NoticeSink is the destination interface for a NoticeDispatcher. Each sink
+// receives the fully-decoded NoticeObject, so it need not re-parse the
+// JSON notice line.
+type NoticeSink interface {
+
+	// HandleNotice delivers a decoded notice to the sink. A NoticeDispatcher
+	// calls it from the sink's own delivery goroutine, and
+	// NoticeConsoleRewriter calls it from Write while holding its mutex;
+	// either way it's never called concurrently, so implementations don't
+	// need to guard against concurrent calls to
+	// HandleNotice; they do need to guard any state also read elsewhere,
+	// such as from a Snapshot accessor.
+	HandleNotice(notice *NoticeObject)
+
+	// Close releases any resources held by the sink, such as open files
+	// or network connections.
+	Close() error
+}
+
+// noticeSinkChannelSize is the number of notices buffered per sink before
+// the dispatcher starts dropping the oldest queued notice to make room for
+// the newest. This keeps a slow or stuck sink, such as a syslog socket
+// that's stopped accepting writes, from stalling notice delivery to every
+// other sink, or the tunnel itself.
+const noticeSinkChannelSize = 100
+
+// noticeTypeSinkDroppedNotices is the NoticeType used when a sink's queue
+// overflows and the dispatcher has to drop notices to keep up.
+const noticeTypeSinkDroppedNotices = "SinkDroppedNotices"
+
+// NoticeDispatcher parses a stream of JSON-format notice lines once and
+// fans out the decoded NoticeObject to an ordered list of NoticeSinks.
+// Each sink is delivered to via its own bounded channel and goroutine, so
+// a sink that blocks or falls behind only drops its own backlog -- oldest
+// notice first -- instead of blocking the other sinks or the writer.
+type NoticeDispatcher struct {
+	mutex   sync.Mutex
+	buffer  []byte
+	runners []*noticeSinkRunner
+}
+
+// NewNoticeDispatcher initializes a NoticeDispatcher that fans out to the
+// given sinks, in order. Use NewNoticeDispatcher(NewConsoleSink(...)) to
+// replicate a single NoticeConsoleRewriter, or pass additional sinks, such
+// as a RotatingFileSink or RingBufferSink, to tee notices elsewhere.
+func NewNoticeDispatcher(sinks ...NoticeSink) *NoticeDispatcher {
+	dispatcher := &NoticeDispatcher{}
+	for _, sink := range sinks {
+		runner := &noticeSinkRunner{
+			name:       fmt.Sprintf("%T", sink),
+			sink:       sink,
+			notices:    make(chan *NoticeObject, noticeSinkChannelSize),
+			dispatcher: dispatcher,
+		}
+		runner.wg.Add(1)
+		go runner.run()
+		dispatcher.runners = append(dispatcher.runners, runner)
+	}
+	return dispatcher
+}
+
+// Write implements io.Writer. It parses each complete JSON notice line in
+// p exactly once and dispatches the decoded NoticeObject to every sink.
+func (dispatcher *NoticeDispatcher) Write(p []byte) (n int, err error) {
+	dispatcher.mutex.Lock()
+	defer dispatcher.mutex.Unlock()
+
+	dispatcher.buffer = append(dispatcher.buffer, p...)
+
+	for {
+		index := bytes.IndexByte(dispatcher.buffer, '\n')
+		if index == -1 {
+			break
+		}
+		line := dispatcher.buffer[:index]
+		dispatcher.buffer = dispatcher.buffer[index+1:]
+
+		var notice NoticeObject
+		_ = json.Unmarshal(line, &notice)
+
+		// line aliases dispatcher.buffer's underlying array, which is
+		// about to be overwritten by future appends, so it must be
+		// copied before being retained past this iteration; see
+		// NoticeObject.rawLine.
+		notice.rawLine = append([]byte(nil), line...)
+
+		dispatcher.dispatch(&notice)
+	}
+
+	return len(p), nil
+}
+
+// Close stops and closes every sink, returning the first error
+// encountered, if any. Close waits for each sink's queued notices to
+// finish delivering before closing the sink.
+func (dispatcher *NoticeDispatcher) Close() error {
+	for _, runner := range dispatcher.runners {
+		close(runner.notices)
+	}
+
+	var firstErr error
+	for _, runner := range dispatcher.runners {
+		runner.wg.Wait()
+		if err := runner.sink.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (dispatcher *NoticeDispatcher) dispatch(notice *NoticeObject) {
+	for _, runner := range dispatcher.runners {
+		runner.enqueue(notice)
+	}
+}
+
+// emitDroppedNotice synthesizes and dispatches a SinkDroppedNotices notice
+// recording that sinkName has dropped droppedCount notices so far. It's
+// not emitted when the overflow was caused by enqueuing a
+// SinkDroppedNotices notice itself, which would otherwise recurse under
+// sustained overflow.
+func (dispatcher *NoticeDispatcher) emitDroppedNotice(sinkName string, droppedCount int64) {
+	data, err := json.Marshal(struct {
+		Sink    string `json:"sink"`
+		Dropped int64  `json:"dropped"`
+	}{
+		Sink:    sinkName,
+		Dropped: droppedCount,
+	})
+	if err != nil {
+		return
+	}
+
+	dispatcher.dispatch(&NoticeObject{
+		NoticeType: noticeTypeSinkDroppedNotices,
+		Data:       data,
+		Timestamp:  time.Now().UTC().Format(time.RFC3339Nano),
+		Severity:   NoticeSeverityWarn.String(),
+	})
+}
+
+// noticeSinkRunner owns the delivery goroutine and bounded channel for a
+// single sink.
+type noticeSinkRunner struct {
+	name       string
+	sink       NoticeSink
+	notices    chan *NoticeObject
+	dropped    int64
+	dispatcher *NoticeDispatcher
+	wg         sync.WaitGroup
+}
+
+func (runner *noticeSinkRunner) run() {
+	defer runner.wg.Done()
+	for notice := range runner.notices {
+		runner.sink.HandleNotice(notice)
+	}
+}
+
+// enqueue delivers notice to the runner's channel without blocking. If the
+// channel is full, the oldest queued notice is dropped to make room and
+// the runner's dropped counter is surfaced as its own notice.
+func (runner *noticeSinkRunner) enqueue(notice *NoticeObject) {
+	select {
+	case runner.notices <- notice:
+		return
+	default:
+	}
+
+	select {
+	case <-runner.notices:
+	default:
+	}
+
+	select {
+	case runner.notices <- notice:
+	default:
+	}
+
+	droppedCount := atomic.AddInt64(&runner.dropped, 1)
+	if notice.NoticeType != noticeTypeSinkDroppedNotices {
+		runner.dispatcher.emitDroppedNotice(runner.name, droppedCount)
+	}
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright (c) 2015, Psiphon Inc.
+ * All rights reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package psiphon
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+// noopSink is a NoticeSink that discards every notice; it's only used to
+// satisfy the NoticeSink interface in tests that exercise noticeSinkRunner
+// directly, without starting its delivery goroutine.
+type noopSink struct{}
+
+func (*noopSink) HandleNotice(notice *NoticeObject) {}
+func (*noopSink) Close() error                      { return nil }
+
+// TestNoticeSinkRunnerDropOldest exercises noticeSinkRunner.enqueue
+// directly, without starting its delivery goroutine, so the channel fills
+// up and drop-oldest kicks in deterministically.
+func TestNoticeSinkRunnerDropOldest(t *testing.T) {
+
+	dispatcher := &NoticeDispatcher{}
+	runner := &noticeSinkRunner{
+		name:       "test-sink",
+		sink:       &noopSink{},
+		notices:    make(chan *NoticeObject, 1),
+		dispatcher: dispatcher,
+	}
+	dispatcher.runners = []*noticeSinkRunner{runner}
+
+	runner.enqueue(&NoticeObject{NoticeType: "first"})
+
+	// The channel (capacity 1) is now full. This enqueue must drop
+	// "first" to make room, which also triggers a SinkDroppedNotices
+	// notice -- itself enqueued into the same full channel, which drops
+	// "second" in turn. Since the notice whose enqueue caused that drop is
+	// of type SinkDroppedNotices, it must not trigger a further dropped
+	// notice.
+	runner.enqueue(&NoticeObject{NoticeType: "second"})
+
+	if atomic.LoadInt64(&runner.dropped) != 2 {
+		t.Fatalf("expected dropped count of 2, got %d", runner.dropped)
+	}
+
+	select {
+	case notice := <-runner.notices:
+		if notice.NoticeType != noticeTypeSinkDroppedNotices {
+			t.Fatalf("expected a %s notice, got %s", noticeTypeSinkDroppedNotices, notice.NoticeType)
+		}
+	default:
+		t.Fatal("expected a queued notice, got none")
+	}
+
+	select {
+	case notice := <-runner.notices:
+		t.Fatalf("expected no further queued notices, got %s", notice.NoticeType)
+	default:
+	}
+}
+
+// TestNoticeSinkRunnerNoOverflowWithinCapacity verifies that enqueue does
+// not drop notices, or report any as dropped, while the channel has room.
+func TestNoticeSinkRunnerNoOverflowWithinCapacity(t *testing.T) {
+
+	dispatcher := &NoticeDispatcher{}
+	runner := &noticeSinkRunner{
+		name:       "test-sink",
+		sink:       &noopSink{},
+		notices:    make(chan *NoticeObject, 2),
+		dispatcher: dispatcher,
+	}
+	dispatcher.runners = []*noticeSinkRunner{runner}
+
+	runner.enqueue(&NoticeObject{NoticeType: "first"})
+	runner.enqueue(&NoticeObject{NoticeType: "second"})
+
+	if atomic.LoadInt64(&runner.dropped) != 0 {
+		t.Fatalf("expected dropped count of 0, got %d", runner.dropped)
+	}
+
+	first := <-runner.notices
+	if first.NoticeType != "first" {
+		t.Fatalf("expected \"first\", got %s", first.NoticeType)
+	}
+	second := <-runner.notices
+	if second.NoticeType != "second" {
+		t.Fatalf("expected \"second\", got %s", second.NoticeType)
+	}
+}
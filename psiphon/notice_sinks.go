@@ -0,0 +1,269 @@
+/*
+ * Copyright (c) 2015, Psiphon Inc.
+ * All rights reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package psiphon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RingBufferSink retains only the most recent notices, in memory, for
+// inclusion in feedback/diagnostic uploads when a user reports a
+// connectivity issue.
+type RingBufferSink struct {
+	mutex    sync.Mutex
+	notices  []*NoticeObject
+	capacity int
+	next     int
+	full     bool
+}
+
+// NewRingBufferSink initializes a RingBufferSink retaining up to capacity
+// notices. A capacity of 0 or less is treated as "retain nothing": the
+// sink discards every notice handed to it, rather than panicking.
+func NewRingBufferSink(capacity int) *RingBufferSink {
+	if capacity < 0 {
+		capacity = 0
+	}
+	return &RingBufferSink{
+		notices:  make([]*NoticeObject, capacity),
+		capacity: capacity,
+	}
+}
+
+// HandleNotice implements NoticeSink.
+func (sink *RingBufferSink) HandleNotice(notice *NoticeObject) {
+	if sink.capacity == 0 {
+		return
+	}
+
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+
+	sink.notices[sink.next] = notice
+	sink.next = (sink.next + 1) % sink.capacity
+	if sink.next == 0 {
+		sink.full = true
+	}
+}
+
+// Snapshot returns up to the last capacity notices handled, oldest first.
+func (sink *RingBufferSink) Snapshot() []*NoticeObject {
+	if sink.capacity == 0 {
+		return nil
+	}
+
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+
+	if !sink.full {
+		snapshot := make([]*NoticeObject, sink.next)
+		copy(snapshot, sink.notices[:sink.next])
+		return snapshot
+	}
+
+	snapshot := make([]*NoticeObject, sink.capacity)
+	copy(snapshot, sink.notices[sink.next:])
+	copy(snapshot[sink.capacity-sink.next:], sink.notices[:sink.next])
+	return snapshot
+}
+
+// Close implements NoticeSink.
+func (sink *RingBufferSink) Close() error {
+	return nil
+}
+
+// RotatingFileSink writes notices, one JSON object per line, to a file
+// that's rotated by size, with old backups pruned by count and age. This
+// keeps a long-running tunnel process from filling its disk with notice
+// output.
+type RotatingFileSink struct {
+	mutex      sync.Mutex
+	filename   string
+	maxSize    int64
+	maxBackups int
+	maxAge     time.Duration
+	file       *os.File
+	size       int64
+}
+
+// NewRotatingFileSink initializes a RotatingFileSink writing to filename.
+// maxSize is the size, in bytes, at which the file is rotated; maxBackups
+// is the number of rotated backups to retain; maxAge, when non-zero, is
+// the maximum age of a backup before it's pruned. A maxSize, maxBackups,
+// or maxAge of 0 disables that limit.
+func NewRotatingFileSink(
+	filename string, maxSize int64, maxBackups int, maxAge time.Duration) (*RotatingFileSink, error) {
+
+	sink := &RotatingFileSink{
+		filename:   filename,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+		maxAge:     maxAge,
+	}
+
+	err := sink.openCurrent()
+	if err != nil {
+		return nil, ContextError(err)
+	}
+
+	return sink, nil
+}
+
+func (sink *RotatingFileSink) openCurrent() error {
+	file, err := os.OpenFile(sink.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	sink.file = file
+	sink.size = info.Size()
+	return nil
+}
+
+// HandleNotice implements NoticeSink.
+func (sink *RotatingFileSink) HandleNotice(notice *NoticeObject) {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+
+	encodedNotice, err := json.Marshal(notice)
+	if err != nil {
+		return
+	}
+	line := append(encodedNotice, '\n')
+
+	if sink.maxSize > 0 && sink.size+int64(len(line)) > sink.maxSize {
+		err := sink.rotate()
+		if err != nil {
+			return
+		}
+	}
+
+	n, err := sink.file.Write(line)
+	if err != nil {
+		return
+	}
+	sink.size += int64(n)
+}
+
+func (sink *RotatingFileSink) rotate() error {
+	err := sink.file.Close()
+	if err != nil {
+		return err
+	}
+
+	backupName := fmt.Sprintf(
+		"%s.%s", sink.filename, time.Now().UTC().Format("20060102T150405.000000000Z"))
+	err = os.Rename(sink.filename, backupName)
+	if err != nil {
+		return err
+	}
+
+	err = sink.openCurrent()
+	if err != nil {
+		return err
+	}
+
+	sink.pruneBackups()
+
+	return nil
+}
+
+func (sink *RotatingFileSink) pruneBackups() {
+	matches, err := filepath.Glob(sink.filename + ".*")
+	if err != nil {
+		return
+	}
+	sort.Strings(matches)
+
+	if sink.maxAge > 0 {
+		cutoff := time.Now().Add(-sink.maxAge)
+		kept := matches[:0]
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil || info.ModTime().Before(cutoff) {
+				os.Remove(match)
+				continue
+			}
+			kept = append(kept, match)
+		}
+		matches = kept
+	}
+
+	if sink.maxBackups > 0 && len(matches) > sink.maxBackups {
+		for _, match := range matches[:len(matches)-sink.maxBackups] {
+			os.Remove(match)
+		}
+	}
+}
+
+// Close implements NoticeSink.
+func (sink *RotatingFileSink) Close() error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	return sink.file.Close()
+}
+
+// WebhookSink forwards each notice as an HTTP POST of the JSON-encoded
+// notice body to a configured URL.
+type WebhookSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookSink initializes a WebhookSink posting to url.
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// HandleNotice implements NoticeSink. Delivery failures are dropped; the
+// dispatcher's per-sink queue and drop counter already account for a
+// webhook endpoint that's slow or unreachable.
+func (sink *WebhookSink) HandleNotice(notice *NoticeObject) {
+	encodedNotice, err := json.Marshal(notice)
+	if err != nil {
+		return
+	}
+
+	response, err := sink.client.Post(sink.url, "application/json", bytes.NewReader(encodedNotice))
+	if err != nil {
+		return
+	}
+	response.Body.Close()
+}
+
+// Close implements NoticeSink.
+func (sink *WebhookSink) Close() error {
+	return nil
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) 2015, Psiphon Inc.
+ * All rights reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package psiphon
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRingBufferSinkWraparound(t *testing.T) {
+
+	sink := NewRingBufferSink(3)
+
+	for i := 0; i < 5; i++ {
+		sink.HandleNotice(&NoticeObject{NoticeType: fmt.Sprintf("notice-%d", i)})
+	}
+
+	snapshot := sink.Snapshot()
+
+	expected := []string{"notice-2", "notice-3", "notice-4"}
+	if len(snapshot) != len(expected) {
+		t.Fatalf("expected %d notices, got %d", len(expected), len(snapshot))
+	}
+	for i, noticeType := range expected {
+		if snapshot[i].NoticeType != noticeType {
+			t.Errorf("expected snapshot[%d] to be %s, got %s", i, noticeType, snapshot[i].NoticeType)
+		}
+	}
+}
+
+func TestRingBufferSinkBelowCapacity(t *testing.T) {
+
+	sink := NewRingBufferSink(5)
+
+	sink.HandleNotice(&NoticeObject{NoticeType: "notice-0"})
+	sink.HandleNotice(&NoticeObject{NoticeType: "notice-1"})
+
+	snapshot := sink.Snapshot()
+
+	expected := []string{"notice-0", "notice-1"}
+	if len(snapshot) != len(expected) {
+		t.Fatalf("expected %d notices, got %d", len(expected), len(snapshot))
+	}
+	for i, noticeType := range expected {
+		if snapshot[i].NoticeType != noticeType {
+			t.Errorf("expected snapshot[%d] to be %s, got %s", i, noticeType, snapshot[i].NoticeType)
+		}
+	}
+}
+
+func TestRingBufferSinkZeroCapacity(t *testing.T) {
+
+	sink := NewRingBufferSink(0)
+
+	// Must not panic (previously divided by zero/indexed out of range).
+	sink.HandleNotice(&NoticeObject{NoticeType: "notice-0"})
+
+	if snapshot := sink.Snapshot(); snapshot != nil {
+		t.Errorf("expected nil snapshot, got %v", snapshot)
+	}
+}
+
+func TestRingBufferSinkNegativeCapacity(t *testing.T) {
+
+	sink := NewRingBufferSink(-1)
+
+	sink.HandleNotice(&NoticeObject{NoticeType: "notice-0"})
+
+	if snapshot := sink.Snapshot(); snapshot != nil {
+		t.Errorf("expected nil snapshot, got %v", snapshot)
+	}
+}
@@ -0,0 +1,68 @@
+//go:build !windows
+// +build !windows
+
+/*
+ * Copyright (c) 2015, Psiphon Inc.
+ * All rights reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package psiphon
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+// SyslogSink forwards notices to the local syslog daemon, at a priority
+// derived from the notice's severity.
+type SyslogSink struct {
+	writer *syslog.Writer
+}
+
+// NewSyslogSink initializes a SyslogSink identifying itself as tag.
+func NewSyslogSink(tag string) (*SyslogSink, error) {
+	writer, err := syslog.New(syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, ContextError(err)
+	}
+	return &SyslogSink{writer: writer}, nil
+}
+
+// HandleNotice implements NoticeSink.
+func (sink *SyslogSink) HandleNotice(notice *NoticeObject) {
+	encodedNotice, err := json.Marshal(notice)
+	if err != nil {
+		return
+	}
+	message := string(encodedNotice)
+
+	switch parseNoticeSeverity(notice.Severity) {
+	case NoticeSeverityDebug:
+		sink.writer.Debug(message)
+	case NoticeSeverityWarn:
+		sink.writer.Warning(message)
+	case NoticeSeverityError:
+		sink.writer.Err(message)
+	default:
+		sink.writer.Info(message)
+	}
+}
+
+// Close implements NoticeSink.
+func (sink *SyslogSink) Close() error {
+	return sink.writer.Close()
+}
@@ -0,0 +1,45 @@
+//go:build windows
+// +build windows
+
+/*
+ * Copyright (c) 2015, Psiphon Inc.
+ * All rights reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package psiphon
+
+import (
+	"errors"
+)
+
+// SyslogSink is not supported on Windows, which has no log/syslog
+// equivalent in the standard library.
+type SyslogSink struct{}
+
+// NewSyslogSink always fails on this platform.
+func NewSyslogSink(tag string) (*SyslogSink, error) {
+	return nil, errors.New("syslog notice sink is not supported on this platform")
+}
+
+// HandleNotice implements NoticeSink.
+func (sink *SyslogSink) HandleNotice(notice *NoticeObject) {
+}
+
+// Close implements NoticeSink.
+func (sink *SyslogSink) Close() error {
+	return nil
+}
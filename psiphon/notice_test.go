@@ -0,0 +1,66 @@
+/*
+ * Copyright (c) 2015, Psiphon Inc.
+ * All rights reserved.
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package psiphon
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestNoticeConsoleRewriterFormatJSONPassthrough verifies that
+// NoticeConsoleRewriterFormatJSON emits the original notice line
+// unmodified, including fields NoticeObject doesn't know about, rather
+// than re-encoding just the known fields and losing them.
+func TestNoticeConsoleRewriterFormatJSONPassthrough(t *testing.T) {
+
+	var output bytes.Buffer
+	rewriter := NewNoticeConsoleRewriter(
+		&output,
+		NoticeSeverityDebug,
+		NoticeConsoleRewriterFormatOption(NoticeConsoleRewriterFormatJSON))
+
+	inputLine := `{"noticeType":"Test","data":{},"timestamp":"t","showUser":true}` + "\n"
+	if _, err := rewriter.Write([]byte(inputLine)); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+
+	if output.String() != inputLine {
+		t.Fatalf("expected passthrough line %q, got %q", inputLine, output.String())
+	}
+}
+
+// TestNoticeConsoleRewriterFormatJSONSynthesized verifies that a notice
+// with no original line, such as one synthesized by the dispatcher, is
+// re-encoded from its known fields rather than dropped.
+func TestNoticeConsoleRewriterFormatJSONSynthesized(t *testing.T) {
+
+	var output bytes.Buffer
+	sink := NewConsoleSink(
+		&output,
+		NoticeSeverityDebug,
+		NoticeConsoleRewriterFormatOption(NoticeConsoleRewriterFormatJSON))
+
+	sink.HandleNotice(&NoticeObject{NoticeType: "Synthesized", Timestamp: "t"})
+
+	if !strings.Contains(output.String(), `"noticeType":"Synthesized"`) {
+		t.Fatalf("expected re-encoded notice, got %q", output.String())
+	}
+}